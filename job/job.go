@@ -1,6 +1,8 @@
 package job
 
 import (
+	"bytes"
+	"fmt"
 	"gonzbee/config"
 	"gonzbee/nntp"
 	"gonzbee/nzb"
@@ -8,6 +10,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sync"
 )
 
 //The Job struct holds all the information needed in order to download
@@ -15,6 +18,13 @@ import (
 type Job struct {
 	Name string
 	Nzb  *nzb.Nzb
+
+	// Force discards any existing resume state for this job instead of
+	// skipping segments it says are already complete.
+	Force bool
+	// Fsync makes every state checkpoint call fsync before returning, at
+	// the cost of checkpointing throughput.
+	Fsync bool
 }
 
 //FromFile creates a download job from a NZB file
@@ -32,25 +42,141 @@ func FromFile(filepath string) (*Job, error) {
 	return j, nil
 }
 
-//Start will execute a job on the given NNTP connection
-func (j *Job) Start(nntpConn *nntp.Conn) error {
-	path := config.C.GetIncompleteDir()
-	jobDir := filepath.Join(path, j.Name)
+// Resume loads a job from the NZB file at filepath and sets Name to dir's
+// base name. It does not itself read any state from dir: Start is what loads
+// the checkpoint log, and it looks for it under IncompleteDir/Name, so dir
+// only takes effect if its base name matches the job directory Start would
+// use. Callers that keep jobs under IncompleteDir by name can pass that
+// directory here instead of computing the name themselves.
+func Resume(filepath, dir string) (*Job, error) {
+	j, err := FromFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	j.Name = path.Base(dir)
+	return j, nil
+}
+
+// segmentTask is a single (file, segment) pair pulled off the shared task
+// channel by a worker.
+type segmentTask struct {
+	fileIdx int
+	file    *nzb.File
+	seg     nzb.Segment
+}
+
+//Start downloads every segment of the job using the connections checked out
+//of pool. It runs a fixed set of worker goroutines, each pulling tasks from
+//a shared channel and reusing its checked-out connection across tasks, and
+//returns an aggregate error if any segments failed.
+//
+//Start checkpoints completed segments to a sidecar state file in the job
+//directory, so a job killed mid-download can be resumed by calling Start
+//again on a Job with the same Name: segments the state file already has
+//recorded are skipped, provided their recorded byte range is still on disk
+//with a matching CRC32. Set j.Force to ignore any existing state and
+//re-fetch everything.
+func (j *Job) Start(pool *nntp.Pool, workers int) error {
+	dir := config.C.GetIncompleteDir()
+	jobDir := filepath.Join(dir, j.Name)
 	os.Mkdir(jobDir, 0777)
-	for _, file := range j.Nzb.File {
-		nntpConn.SwitchGroup(file.Groups[0])
-		for _, seg := range file.Segments {
-			contents, err := nntpConn.GetMessageReader(seg.MsgId)
-			if err != nil {
-				continue
+
+	var state *jobState
+	if j.Force {
+		state = newJobState(jobDir, j.Fsync)
+	} else {
+		state = loadJobState(jobDir, j.Fsync)
+	}
+
+	tasks := make(chan segmentTask)
+	go func() {
+		defer close(tasks)
+		for fileIdx, file := range j.Nzb.File {
+			for _, seg := range file.Segments {
+				if state.isDone(fileIdx, seg.MsgId) {
+					continue
+				}
+				tasks <- segmentTask{fileIdx, file, seg}
 			}
-			part, _ := yenc.NewPart(contents)
-			file, _ := os.OpenFile(filepath.Join(jobDir, part.Name), os.O_WRONLY|os.O_CREATE, 0644)
-			file.Seek(part.Begin, os.SEEK_SET)
-			part.Decode(file)
-			file.Close()
-			contents.Close()
 		}
+	}()
+
+	var wg sync.WaitGroup
+	var failedMu sync.Mutex
+	var failed []error
+	recordErr := func(err error) {
+		failedMu.Lock()
+		failed = append(failed, err)
+		failedMu.Unlock()
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := pool.Get()
+			defer pool.Put(conn)
+
+			group := ""
+			for t := range tasks {
+				if len(t.file.Groups) > 0 && t.file.Groups[0] != group {
+					if err := conn.SwitchGroup(t.file.Groups[0]); err != nil {
+						recordErr(err)
+						continue
+					}
+					group = t.file.Groups[0]
+				}
+				if err := downloadSegment(conn, jobDir, t, state); err != nil {
+					recordErr(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return fmt.Errorf("job: %d segment(s) failed, first error: %v", len(failed), failed[0])
 	}
 	return nil
 }
+
+// downloadSegment fetches a single segment's article, yenc-decodes it, and
+// writes the decoded bytes into the segment's file at part.Begin using
+// WriteAt, so concurrent workers can write to the same file safely without
+// seeking. On success it checkpoints the segment into state so a later
+// resume can skip it.
+func downloadSegment(conn *nntp.Conn, jobDir string, t segmentTask, state *jobState) error {
+	contents, err := conn.GetMessageReader(t.seg.MsgId)
+	if err != nil {
+		return err
+	}
+	defer contents.Close()
+
+	part, err := yenc.NewPart(contents)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(jobDir, part.Name), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	if err := part.Decode(&buf); err != nil {
+		return err
+	}
+	end := part.Begin + int64(buf.Len())
+	if _, err := f.WriteAt(buf.Bytes(), part.Begin); err != nil {
+		return err
+	}
+
+	return state.markDone(t.fileIdx, segmentState{
+		MsgId:    t.seg.MsgId,
+		Filename: part.Name,
+		Begin:    part.Begin,
+		End:      end,
+		CRC32:    part.CRC32,
+	})
+}
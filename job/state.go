@@ -0,0 +1,164 @@
+package job
+
+import (
+	"bufio"
+	"encoding/json"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+const stateFileName = ".gonzbee-state.json"
+
+// segmentState records a fetched segment's file, byte range, and yEnc CRC32,
+// so a resume can tell a segment that's still intact on disk from one whose
+// output was truncated, deleted, or corrupted since the checkpoint.
+type segmentState struct {
+	MsgId    string `json:"msg_id"`
+	Filename string `json:"filename"`
+	Begin    int64  `json:"begin"`
+	End      int64  `json:"end"`
+	CRC32    uint32 `json:"crc32"`
+}
+
+// fileState is the set of completed segments for one nzb.File, keyed by
+// MsgId.
+type fileState struct {
+	Segments map[string]segmentState
+}
+
+// logRecord is one line of the checkpoint log: a completed segment and the
+// index of the nzb.File it belongs to.
+type logRecord struct {
+	FileIdx string       `json:"file_idx"`
+	Seg     segmentState `json:"seg"`
+}
+
+// jobState is the in-memory index built from the append-only checkpoint log
+// at <jobdir>/.gonzbee-state.json. Files is keyed by nzb.File index, which
+// is stable across runs of the same NZB.
+type jobState struct {
+	mu      sync.Mutex
+	jobDir  string
+	fsync   bool
+	logFile *os.File
+	Files   map[string]*fileState
+}
+
+func (s *jobState) logPath() string {
+	return filepath.Join(s.jobDir, stateFileName)
+}
+
+// newJobState starts a fresh checkpoint log in jobDir, truncating any
+// existing one (used when the caller passed Force).
+func newJobState(jobDir string, fsync bool) *jobState {
+	st := &jobState{jobDir: jobDir, fsync: fsync, Files: make(map[string]*fileState)}
+	f, err := os.OpenFile(st.logPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err == nil {
+		st.logFile = f
+	}
+	return st
+}
+
+// loadJobState replays the checkpoint log in jobDir, if any, then reopens it
+// for appending. A missing or corrupt log is treated as an empty one.
+func loadJobState(jobDir string, fsync bool) *jobState {
+	st := &jobState{jobDir: jobDir, fsync: fsync, Files: make(map[string]*fileState)}
+	st.replay()
+	f, err := os.OpenFile(st.logPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err == nil {
+		st.logFile = f
+	}
+	return st
+}
+
+func (s *jobState) replay() {
+	f, err := os.Open(s.logPath())
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var rec logRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// half-written last line from a crash mid-append; skip it.
+			continue
+		}
+		fs, ok := s.Files[rec.FileIdx]
+		if !ok {
+			fs = &fileState{Segments: make(map[string]segmentState)}
+			s.Files[rec.FileIdx] = fs
+		}
+		fs.Segments[rec.Seg.MsgId] = rec.Seg
+	}
+}
+
+// isDone reports whether segment msgId of file fileIdx was checkpointed in a
+// previous run and its recorded byte range is still intact on disk.
+func (s *jobState) isDone(fileIdx int, msgId string) bool {
+	s.mu.Lock()
+	fs, ok := s.Files[strconv.Itoa(fileIdx)]
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+	seg, ok := fs.Segments[msgId]
+	if !ok {
+		return false
+	}
+	return s.segmentIntact(seg)
+}
+
+func (s *jobState) segmentIntact(seg segmentState) bool {
+	f, err := os.Open(filepath.Join(s.jobDir, seg.Filename))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.Size() < seg.End {
+		return false
+	}
+	buf := make([]byte, seg.End-seg.Begin)
+	if _, err := f.ReadAt(buf, seg.Begin); err != nil {
+		return false
+	}
+	return crc32.ChecksumIEEE(buf) == seg.CRC32
+}
+
+// markDone records a completed segment by appending one line to the
+// checkpoint log, rather than rewriting the whole document per segment.
+func (s *jobState) markDone(fileIdx int, seg segmentState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strconv.Itoa(fileIdx)
+	fs, ok := s.Files[key]
+	if !ok {
+		fs = &fileState{Segments: make(map[string]segmentState)}
+		s.Files[key] = fs
+	}
+	fs.Segments[seg.MsgId] = seg
+
+	if s.logFile == nil {
+		return nil
+	}
+	data, err := json.Marshal(logRecord{FileIdx: key, Seg: seg})
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := s.logFile.Write(data); err != nil {
+		return err
+	}
+	if s.fsync {
+		return s.logFile.Sync()
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package job
+
+import (
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateCheckpointReplayIsDone(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("hello world")
+	if err := os.WriteFile(filepath.Join(dir, "out.dat"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	seg := segmentState{
+		MsgId:    "<1@test>",
+		Filename: "out.dat",
+		Begin:    0,
+		End:      int64(len(data)),
+		CRC32:    crc32.ChecksumIEEE(data),
+	}
+
+	st := newJobState(dir, false)
+	if st.isDone(0, seg.MsgId) {
+		t.Fatal("isDone true before markDone")
+	}
+	if err := st.markDone(0, seg); err != nil {
+		t.Fatal(err)
+	}
+	if !st.isDone(0, seg.MsgId) {
+		t.Fatal("isDone false right after markDone")
+	}
+
+	reloaded := loadJobState(dir, false)
+	if !reloaded.isDone(0, seg.MsgId) {
+		t.Fatal("isDone false after reload: checkpoint didn't survive replay")
+	}
+	if reloaded.isDone(1, seg.MsgId) {
+		t.Fatal("isDone true for a file index that was never marked done")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "out.dat"), []byte("corrupted!!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	corrupted := loadJobState(dir, false)
+	if corrupted.isDone(0, seg.MsgId) {
+		t.Fatal("isDone true after the on-disk segment was corrupted")
+	}
+}
@@ -0,0 +1,55 @@
+package nntp
+
+import (
+	"gonzbee/config"
+)
+
+// Pool is a fixed-size set of authenticated NNTP connections that workers
+// can check out and return, so segment downloads can proceed in parallel
+// without dialing a new connection per segment.
+type Pool struct {
+	conns chan *Conn
+}
+
+// NewPool dials config.C.GetConnections() connections to the server
+// described by config.C, authenticates each of them, and returns a Pool
+// ready to hand them out. If any connection fails to dial or authenticate,
+// the connections already opened are closed and the error is returned.
+func NewPool() (*Pool, error) {
+	n := config.C.GetConnections()
+	p := &Pool{conns: make(chan *Conn, n)}
+	for i := 0; i < n; i++ {
+		conn, err := Dial(config.C.GetHost(), config.C.GetPort())
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		if err := conn.Authenticate(config.C.GetUser(), config.C.GetPass()); err != nil {
+			conn.Close()
+			p.Close()
+			return nil, err
+		}
+		p.conns <- conn
+	}
+	return p, nil
+}
+
+// Get blocks until a connection is available and removes it from the pool.
+func (p *Pool) Get() *Conn {
+	return <-p.conns
+}
+
+// Put returns a connection to the pool so another worker can reuse it.
+func (p *Pool) Put(c *Conn) {
+	p.conns <- c
+}
+
+// Close closes every connection currently checked into the pool.
+// Connections checked out via Get at the time of Close are the caller's
+// responsibility to close.
+func (p *Pool) Close() {
+	close(p.conns)
+	for c := range p.conns {
+		c.Close()
+	}
+}
@@ -8,9 +8,12 @@ import (
 	"errors"
 	"fmt"
 	"hash"
+	"hash/crc32"
 	"io"
 	"math/big"
 	"os"
+	"runtime"
+	"sync"
 )
 
 type Fileset struct {
@@ -19,12 +22,18 @@ type Fileset struct {
 	complete  bool
 	files     map[[16]byte]*File
 	checksums map[[16]byte]chksum
+	crcIndex  map[uint32][]chksum
+	order     [][16]byte
+	recovery  []recvSlice
 }
 
 type File struct {
 	Name      string
 	length    uint64
+	md5       [16]byte
+	md5_16k   [16]byte
 	checksums [][16]byte
+	crc32s    []uint32
 }
 
 func (f *File) numBlocks(fset *Fileset) int {
@@ -38,6 +47,7 @@ func (f *File) numBlocks(fset *Fileset) int {
 type chksum struct {
 	*File
 	blockno int
+	crc32   uint32
 }
 
 // NewFileset reads r and returns a Fileset that can be used for verification and recovery of the files.
@@ -45,6 +55,7 @@ func NewFileset(r io.Reader) *Fileset {
 	fset := &Fileset{}
 	fset.files = make(map[[16]byte]*File)
 	fset.checksums = make(map[[16]byte]chksum)
+	fset.crcIndex = make(map[uint32][]chksum)
 	bufr := bufio.NewReader(r)
 	for {
 		hdr, err := readHeader(bufr)
@@ -76,7 +87,7 @@ func NewFileset(r io.Reader) *Fileset {
 				fset.files[id] = f
 			}
 		case typeIFSC:
-			chksums, id := readIFSC(hdr, bufr)
+			chksums, crcs, id := readIFSC(hdr, bufr)
 			if chksums == nil {
 				continue
 			}
@@ -87,11 +98,18 @@ func NewFileset(r io.Reader) *Fileset {
 			}
 			if fi.checksums == nil {
 				fi.checksums = chksums
-			}
-			for i, chk := range fi.checksums {
-				fset.checksums[chk] = chksum{
-					File:    fi,
-					blockno: i,
+				fi.crc32s = crcs
+				// IFSC packets repeat once per recovery volume; only index
+				// blocks the first time we see this file's checksums, or
+				// crcIndex would grow a duplicate entry per volume.
+				for i, chk := range fi.checksums {
+					c := chksum{
+						File:    fi,
+						blockno: i,
+						crc32:   fi.crc32s[i],
+					}
+					fset.checksums[chk] = c
+					fset.crcIndex[c.crc32] = append(fset.crcIndex[c.crc32], c)
 				}
 			}
 		case typeMain:
@@ -102,6 +120,13 @@ func NewFileset(r io.Reader) *Fileset {
 				}
 			}
 			fset.slicelen = slicelen
+			fset.order = ids
+		case typeRecvSlic:
+			rs, err := readRecvSlic(hdr, bufr)
+			if err != nil {
+				continue
+			}
+			fset.recovery = append(fset.recovery, rs)
 		default:
 		}
 	}
@@ -156,6 +181,14 @@ func (f *Fileset) Verify(paths []string) ([]*FileMatch, int) {
 
 var ErrMissing = errors.New("par2: file missing")
 
+// verifyfile scans s for blocks belonging to the recovery set. It dispatches
+// one worker per slicelen-sized region of the file, each reading its region
+// independently via io.SectionReader.ReadAt so regions can be hashed in
+// parallel across cores. A region's CRC32 is computed first and checked
+// against fset.crcIndex; MD5 (and the match bookkeeping it gates) only runs
+// for regions whose CRC32 collides with a known block, which is the common
+// case of a clean match and the rare case of an unlucky mismatch, but skips
+// the majority of blocks that plainly don't belong to the set.
 func (fset *Fileset) verifyfile(s string) (*FileMatch, int) {
 	file, err := os.Open(s)
 	if err != nil {
@@ -163,55 +196,95 @@ func (fset *Fileset) verifyfile(s string) (*FileMatch, int) {
 	}
 	defer file.Close()
 
-	match := &FileMatch{}
-	for {
-		mdchk := md5.New()
-		n, err := io.CopyN(mdchk, file, int64(fset.slicelen))
-		if n == 0 {
-			break
-		}
-		if uint64(n) < fset.slicelen {
+	info, err := file.Stat()
+	if err != nil {
+		return &FileMatch{Err: err}, 0
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, 0
+	}
+	regioncount := int(size / int64(fset.slicelen))
+	if size%int64(fset.slicelen) != 0 {
+		regioncount++
+	}
+
+	match := &FileMatch{Path: s, blocks: &big.Int{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+
+	for k := 0; k < regioncount; k++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(k int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			off := int64(k) * int64(fset.slicelen)
+			n := int64(fset.slicelen)
+			if off+n > size {
+				n = size - off
+			}
+			region := make([]byte, fset.slicelen)
+			sr := io.NewSectionReader(file, off, n)
+			io.ReadFull(sr, region[:n])
 			// we have a partial block. par2 spec says that we should
-			// fill the remainder with 0s
-			// Ugh.
-			for i := uint64(0); i < fset.slicelen-uint64(n); i++ {
-				// can't fail.
-				mdchk.Write(zero)
+			// fill the remainder with 0s.
+			for i := n; i < int64(fset.slicelen); i++ {
+				region[i] = 0
 			}
-		}
-		var md5sum [16]byte
-		mdchk.Sum(md5sum[:0])
-		if f, ok := fset.checksums[md5sum]; ok {
-			if match.File == nil {
-				// ok we have a match, init the block bitmap
-				match.blocks = &big.Int{}
-				match.File = f.File
-				match.Path = s
-			} else if match.File != f.File {
-				// we already decided on one file and now we have
-				// another file with the same block.
-				//
-				// Effort.
-				continue
+
+			crc := crc32.ChecksumIEEE(region)
+			candidates, ok := fset.crcIndex[crc]
+			if !ok {
+				return
 			}
-			match.blocks.SetBit(match.blocks, f.blockno, 1)
-		}
-		if err != nil {
-			break
-		}
+			md5sum := md5.Sum(region)
+			for _, c := range candidates {
+				if c.File.checksums[c.blockno] != md5sum {
+					continue
+				}
+				mu.Lock()
+				if match.File == nil {
+					match.File = c.File
+				}
+				if match.File == c.File {
+					match.blocks.SetBit(match.blocks, c.blockno, 1)
+				}
+				mu.Unlock()
+				return
+			}
+		}(k)
 	}
+	wg.Wait()
+
+	if match.File == nil || missingBlocks(fset, match) > 0 {
+		// The fixed-offset pass found nothing, or couldn't account for
+		// every block of the file it matched. Either way, fall back to a
+		// rolling-hash scan: a single inserted or deleted byte earlier in
+		// the file shifts every later block off its slicelen boundary, so
+		// fixed offsets alone can't find them even though the data is
+		// intact.
+		fset.rollingScan(file, size, match)
+	}
+
 	if match.File == nil {
 		// not part of the recovery set.
 		return nil, 0
 	}
+	return match, missingBlocks(fset, match)
+}
+
+func missingBlocks(fset *Fileset, match *FileMatch) int {
 	blockcount := match.File.numBlocks(fset)
-	blocksmissing := 0
+	missing := 0
 	for i := 0; i < blockcount; i++ {
 		if match.blocks.Bit(i) == 0 {
-			blocksmissing++
+			missing++
 		}
 	}
-	return match, blocksmissing
+	return missing
 }
 
 type FileMatch struct {
@@ -325,8 +398,8 @@ func readFileDesc(h hdr, r *bufio.Reader) (f *File, id [16]byte) {
 	}
 	f = new(File)
 	id, buf = readmd5(buf)
-	_, buf = readmd5(buf)
-	_, buf = readmd5(buf)
+	f.md5, buf = readmd5(buf)
+	f.md5_16k, buf = readmd5(buf)
 	f.length, buf = readint(buf)
 
 	// rest of block is name, trim 0 padding.
@@ -335,23 +408,45 @@ func readFileDesc(h hdr, r *bufio.Reader) (f *File, id [16]byte) {
 	return f, id
 }
 
-var zero = []byte{0}
-
-func readIFSC(h hdr, r *bufio.Reader) (ss [][16]byte, id [16]byte) {
+func readIFSC(h hdr, r *bufio.Reader) (ss [][16]byte, crcs []uint32, id [16]byte) {
 	buf, err := readPkt(h, r)
 	if err != nil {
-		return nil, id
+		return nil, nil, id
 	}
 	id, buf = readmd5(buf)
 	ss = make([][16]byte, 0, len(buf)/20)
+	crcs = make([]uint32, 0, len(buf)/20)
 	for len(buf) > 0 {
 		var md5h [16]byte
+		var crc uint32
 		md5h, buf = readmd5(buf)
-		// don't care about the crc, just that it gets consumed
-		_, buf = readcrc(buf)
+		crc, buf = readcrc(buf)
 		ss = append(ss, md5h)
+		crcs = append(crcs, crc)
+	}
+	return ss, crcs, id
+}
+
+// recvSlice is a parsed RecvSlic packet: the base exponent for this recovery
+// slice and its recovery data, slicelen bytes read as little-endian GF(2^16)
+// words, one per word position of an input block.
+type recvSlice struct {
+	exponent uint64
+	data     []byte
+}
+
+func readRecvSlic(h hdr, r *bufio.Reader) (recvSlice, error) {
+	buf, err := readPkt(h, r)
+	if err != nil {
+		return recvSlice{}, err
+	}
+	if len(buf) < 4 {
+		return recvSlice{}, errors.New("par2: recovery slice packet too short")
 	}
-	return ss, id
+	exponent, buf := readcrc(buf)
+	data := make([]byte, len(buf))
+	copy(data, buf)
+	return recvSlice{exponent: uint64(exponent), data: data}, nil
 }
 
 func readMain(h hdr, r *bufio.Reader) (slicesize uint64, ids [][16]byte) {
@@ -0,0 +1,378 @@
+package par2
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// GF(2^16) arithmetic, generator polynomial 0x1100B, base 2. Recovery slice
+// i contributes base^(exponent_i * blockExponent(j)) times block j.
+const (
+	gfPoly = 0x1100B
+	gfSize = 1 << 16
+)
+
+var gfExp [2*gfSize - 2]uint16
+var gfLog [gfSize]uint16
+
+func init() {
+	x := 1
+	for i := 0; i < gfSize-1; i++ {
+		gfExp[i] = uint16(x)
+		gfLog[x] = uint16(i)
+		x <<= 1
+		if x >= gfSize {
+			x ^= gfPoly
+		}
+	}
+	for i := gfSize - 1; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-(gfSize-1)]
+	}
+}
+
+func gfMul(a, b uint16) uint16 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// gfPow computes base^e in GF(2^16); e is reduced mod the group order.
+func gfPow(base uint16, e uint64) uint16 {
+	if base == 0 {
+		if e == 0 {
+			return 1
+		}
+		return 0
+	}
+	l := (uint64(gfLog[base]) * e) % uint64(gfSize-1)
+	return gfExp[l]
+}
+
+func gfInv(a uint16) uint16 {
+	return gfExp[gfSize-1-int(gfLog[a])]
+}
+
+// blockExponent returns the base-2 exponent par2cmdline assigns to input
+// block bi: 0,1,2,... skipping multiples of 3, since those generate a
+// subgroup too small to keep every block's column independent (also why
+// PAR2 tops out at 32768 input blocks).
+func blockExponent(bi int) uint64 {
+	e, count := uint64(0), 0
+	for {
+		if e%3 != 0 {
+			if count == bi {
+				return e
+			}
+			count++
+		}
+		e++
+	}
+}
+
+// gfInvertMatrix inverts an n x n matrix over GF(2^16) via Gauss-Jordan.
+func gfInvertMatrix(m [][]uint16) ([][]uint16, error) {
+	n := len(m)
+	a := make([][]uint16, n)
+	inv := make([][]uint16, n)
+	for i := 0; i < n; i++ {
+		a[i] = append([]uint16(nil), m[i]...)
+		inv[i] = make([]uint16, n)
+		inv[i][i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for row := col; row < n; row++ {
+			if a[row][col] != 0 {
+				pivot = row
+				break
+			}
+		}
+		if pivot == -1 {
+			return nil, errors.New("par2: recovery matrix is singular, cannot repair")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+
+		invPivot := gfInv(a[col][col])
+		for k := 0; k < n; k++ {
+			a[col][k] = gfMul(a[col][k], invPivot)
+			inv[col][k] = gfMul(inv[col][k], invPivot)
+		}
+		for row := 0; row < n; row++ {
+			if row == col || a[row][col] == 0 {
+				continue
+			}
+			factor := a[row][col]
+			for k := 0; k < n; k++ {
+				a[row][k] ^= gfMul(factor, a[col][k])
+				inv[row][k] ^= gfMul(factor, inv[col][k])
+			}
+		}
+	}
+	return inv, nil
+}
+
+// blockID identifies a single input block within the recovery set.
+type blockID struct {
+	file    *File
+	blockno int
+}
+
+// blockOrder returns every input block in Main-packet file order, the order
+// recovery slices were computed against.
+func (fset *Fileset) blockOrder() []blockID {
+	var blocks []blockID
+	seen := make(map[*File]bool)
+	for _, id := range fset.order {
+		f, ok := fset.files[id]
+		if !ok || seen[f] {
+			continue
+		}
+		seen[f] = true
+		for b := 0; b < f.numBlocks(fset); b++ {
+			blocks = append(blocks, blockID{f, b})
+		}
+	}
+	return blocks
+}
+
+func bytesToWords(b []byte) []uint16 {
+	w := make([]uint16, (len(b)+1)/2)
+	for i := range w {
+		lo := b[2*i]
+		var hi byte
+		if 2*i+1 < len(b) {
+			hi = b[2*i+1]
+		}
+		w[i] = uint16(lo) | uint16(hi)<<8
+	}
+	return w
+}
+
+func wordsToBytes(w []uint16) []byte {
+	b := make([]byte, len(w)*2)
+	for i, v := range w {
+		b[2*i] = byte(v)
+		b[2*i+1] = byte(v >> 8)
+	}
+	return b
+}
+
+func readBlock(path string, blockno int, slicelen uint64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, slicelen)
+	n, err := f.ReadAt(buf, int64(blockno)*int64(slicelen))
+	if err != nil && err != io.EOF && n == 0 {
+		return nil, err
+	}
+	for i := n; i < len(buf); i++ {
+		buf[i] = 0
+	}
+	return buf, nil
+}
+
+func writeBlock(path string, blockno int, slicelen, filelen uint64, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	off := uint64(blockno) * slicelen
+	n := slicelen
+	if off+n > filelen {
+		n = filelen - off
+	}
+	_, err = f.WriteAt(data[:n], int64(off))
+	return err
+}
+
+// matchPaths maps each file to the path Repair should write its repaired
+// blocks to: a matched path if Verify found one, otherwise one derived
+// under dir from the FileDesc name (the file was entirely missing).
+func matchPaths(matches []*FileMatch, dir string) map[*File]string {
+	paths := make(map[*File]string, len(matches))
+	for _, m := range matches {
+		if m.File == nil {
+			continue
+		}
+		if m.Path != "" {
+			paths[m.File] = m.Path
+			continue
+		}
+		paths[m.File] = filepath.Join(dir, m.File.Name)
+	}
+	return paths
+}
+
+func verifyFileHash(path string, f *File) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	full := md5.New()
+	head := md5.New()
+	_, err = io.Copy(io.MultiWriter(full, &limitedWriter{w: head, n: 16 * 1024}), file)
+	if err != nil {
+		return err
+	}
+	var sum, sum16k [16]byte
+	full.Sum(sum[:0])
+	head.Sum(sum16k[:0])
+	if sum != f.md5 {
+		return fmt.Errorf("par2: repaired file %q failed full MD5 verification", path)
+	}
+	if sum16k != f.md5_16k {
+		return fmt.Errorf("par2: repaired file %q failed MD5-16k verification", path)
+	}
+	return nil
+}
+
+// limitedWriter forwards at most n bytes to w and discards the rest; feeds
+// the MD5-16k hash via io.MultiWriter alongside the full-file MD5 hash.
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if lw.n <= 0 {
+		return len(p), nil
+	}
+	if len(p) > lw.n {
+		if _, err := lw.w.Write(p[:lw.n]); err != nil {
+			return 0, err
+		}
+		lw.n = 0
+		return len(p), nil
+	}
+	n, err := lw.w.Write(p)
+	lw.n -= n
+	return n, err
+}
+
+// Repair reconstructs the blocks matches reports missing using the parsed
+// recovery slices, writing them into the target files named by matches (a
+// file reported entirely missing is recreated under dir). Returns an error
+// if there aren't enough recovery slices, or a repaired file fails its
+// FileDesc MD5 or MD5-16k check.
+func (fset *Fileset) Repair(matches []*FileMatch, dir string) error {
+	present := make(map[blockID]bool)
+	for _, m := range matches {
+		if m.File == nil || m.blocks == nil {
+			continue
+		}
+		for i := 0; i < m.File.numBlocks(fset); i++ {
+			if m.blocks.Bit(i) == 1 {
+				present[blockID{m.File, i}] = true
+			}
+		}
+	}
+
+	blocks := fset.blockOrder()
+	var missing []int
+	for i, b := range blocks {
+		if !present[b] {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if len(missing) > len(fset.recovery) {
+		return fmt.Errorf("par2: not enough recovery blocks to repair: need %d, have %d", len(missing), len(fset.recovery))
+	}
+	if fset.slicelen%2 != 0 {
+		return errors.New("par2: odd slice length not supported")
+	}
+
+	recovery := fset.recovery[:len(missing)]
+	n := len(missing)
+	words := int(fset.slicelen) / 2
+
+	matrix := make([][]uint16, n)
+	for i := range matrix {
+		matrix[i] = make([]uint16, n)
+		for k, bi := range missing {
+			matrix[i][k] = gfPow(2, recovery[i].exponent*blockExponent(bi))
+		}
+	}
+	inv, err := gfInvertMatrix(matrix)
+	if err != nil {
+		return err
+	}
+
+	paths := matchPaths(matches, dir)
+
+	rhs := make([][]uint16, n)
+	for i := range rhs {
+		rhs[i] = bytesToWords(recovery[i].data)
+	}
+	for bi, b := range blocks {
+		if !present[b] {
+			continue
+		}
+		path, ok := paths[b.file]
+		if !ok {
+			continue
+		}
+		data, err := readBlock(path, b.blockno, fset.slicelen)
+		if err != nil {
+			return err
+		}
+		wdata := bytesToWords(data)
+		for i := 0; i < n; i++ {
+			coef := gfPow(2, recovery[i].exponent*blockExponent(bi))
+			if coef == 0 {
+				continue
+			}
+			for w := 0; w < words; w++ {
+				rhs[i][w] ^= gfMul(coef, wdata[w])
+			}
+		}
+	}
+
+	unknown := make([][]uint16, n)
+	for k := 0; k < n; k++ {
+		unknown[k] = make([]uint16, words)
+		for i := 0; i < n; i++ {
+			c := inv[k][i]
+			if c == 0 {
+				continue
+			}
+			for w := 0; w < words; w++ {
+				unknown[k][w] ^= gfMul(c, rhs[i][w])
+			}
+		}
+	}
+
+	touched := make(map[*File]bool)
+	for k, bi := range missing {
+		b := blocks[bi]
+		path, ok := paths[b.file]
+		if !ok {
+			return fmt.Errorf("par2: no target path for file %q", b.file.Name)
+		}
+		if err := writeBlock(path, b.blockno, fset.slicelen, b.file.length, wordsToBytes(unknown[k])); err != nil {
+			return err
+		}
+		touched[b.file] = true
+	}
+
+	for f := range touched {
+		if err := verifyFileHash(paths[f], f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package par2
+
+import (
+	"crypto/md5"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildRecoverySlice encodes a RecvSlic's data the way par2cmdline does: word
+// w of slice i is the XOR, over every input block j, of
+// base^(exponent_i * blockExponent(j)) times word w of block j.
+func buildRecoverySlice(exponent uint64, blocks [][]uint16, words int) []uint16 {
+	out := make([]uint16, words)
+	for j, block := range blocks {
+		coef := gfPow(2, exponent*blockExponent(j))
+		if coef == 0 {
+			continue
+		}
+		for w := 0; w < words; w++ {
+			out[w] ^= gfMul(coef, block[w])
+		}
+	}
+	return out
+}
+
+// TestRepairRoundTrip repairs two blocks missing from a three-block file
+// using two recovery slices built with the real skip-multiples-of-3 base
+// schedule, which only takes effect with block index 2 and above -- a test
+// using plain consecutive exponents would pass even with the old, wrong
+// schedule.
+func TestRepairRoundTrip(t *testing.T) {
+	const slicelen = 4
+	blocksData := [][]uint16{
+		{0x1234, 0x5678},
+		{0x9abc, 0xdef0},
+		{0x1111, 0x2222},
+	}
+
+	recovery := make([]recvSlice, 2)
+	for i := range recovery {
+		exponent := uint64(i + 1)
+		words := buildRecoverySlice(exponent, blocksData, slicelen/2)
+		recovery[i] = recvSlice{exponent: exponent, data: wordsToBytes(words)}
+	}
+
+	var allWords []uint16
+	for _, b := range blocksData {
+		allWords = append(allWords, b...)
+	}
+	full := wordsToBytes(allWords)
+
+	var fileID [16]byte
+	f := &File{
+		Name:   "repaired.dat",
+		length: uint64(len(full)),
+	}
+	f.md5 = md5.Sum(full)
+	head := full
+	if len(head) > 16*1024 {
+		head = head[:16*1024]
+	}
+	f.md5_16k = md5.Sum(head)
+
+	fset := &Fileset{
+		slicelen: slicelen,
+		files:    map[[16]byte]*File{fileID: f},
+		order:    [][16]byte{fileID},
+		recovery: recovery,
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, f.Name)
+	if err := os.WriteFile(path, wordsToBytes(blocksData[0]), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := &big.Int{}
+	blocks.SetBit(blocks, 0, 1)
+	match := &FileMatch{Path: path, File: f, blocks: blocks}
+	if err := fset.Repair([]*FileMatch{match}, dir); err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(full) {
+		t.Fatalf("repaired file mismatch: got %x, want %x", got, full)
+	}
+}
+
+func TestBlockExponentSkipsMultiplesOfThree(t *testing.T) {
+	want := []uint64{1, 2, 4, 5, 7, 8, 10, 11, 13, 14}
+	for i, w := range want {
+		if got := blockExponent(i); got != w {
+			t.Errorf("blockExponent(%d) = %d, want %d", i, got, w)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package par2
+
+import (
+	"bufio"
+	"crypto/md5"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// crcLinMap is a 32x32 matrix over GF(2), as the images of the 32 basis
+// vectors. CRC-32's zero-byte update is linear, so advancing the register by
+// W zero bytes is this map raised to the W-th power -- computed by squaring
+// instead of W sequential steps.
+type crcLinMap [32]uint32
+
+func (m crcLinMap) apply(x uint32) uint32 {
+	var out uint32
+	for i := 0; i < 32; i++ {
+		if x&(1<<uint(i)) != 0 {
+			out ^= m[i]
+		}
+	}
+	return out
+}
+
+// compose returns the map x -> a(b(x)).
+func (a crcLinMap) compose(b crcLinMap) crcLinMap {
+	var out crcLinMap
+	for i := range out {
+		out[i] = a.apply(b[i])
+	}
+	return out
+}
+
+func crcLinIdentity() crcLinMap {
+	var m crcLinMap
+	for i := range m {
+		m[i] = 1 << uint(i)
+	}
+	return m
+}
+
+func (m crcLinMap) pow(n uint64) crcLinMap {
+	result := crcLinIdentity()
+	base := m
+	for n > 0 {
+		if n&1 == 1 {
+			result = result.compose(base)
+		}
+		base = base.compose(base)
+		n >>= 1
+	}
+	return result
+}
+
+// crcZeroMap advances the CRC-32 register by one byte of value zero:
+// z(state) = table[byte(state)] ^ (state >> 8).
+func crcZeroMap() crcLinMap {
+	var m crcLinMap
+	for i := 0; i < 32; i++ {
+		state := uint32(1) << uint(i)
+		m[i] = crc32.IEEETable[byte(state)] ^ (state >> 8)
+	}
+	return m
+}
+
+// rollWindow precomputes what's needed to roll a CRC-32 across a fixed
+// window size without rehashing the whole window on every shift.
+type rollWindow struct {
+	leaving  [256]uint32 // leaving[b]: contribution of a byte b at the window head
+	constant uint32      // folds in CRC-32's initial/final complement
+}
+
+func newRollWindow(windowlen uint64) *rollWindow {
+	zero := crcZeroMap()
+	zeroPow := zero.pow(windowlen - 1)
+	rw := &rollWindow{constant: zero.compose(zeroPow).apply(0xFFFFFFFF)}
+	for b := 0; b < 256; b++ {
+		rw.leaving[b] = zeroPow.apply(crc32.IEEETable[b])
+	}
+	return rw
+}
+
+// foldRaw computes the un-complemented rolling state for a full window of
+// bytes, the same state rollWindow.roll maintains incrementally afterwards.
+func foldRaw(window []byte) uint32 {
+	var raw uint32
+	for _, b := range window {
+		raw = crc32.IEEETable[byte(raw)^b] ^ (raw >> 8)
+	}
+	return raw
+}
+
+// crc converts a rolling state into the same value crc32.ChecksumIEEE would
+// report for the window, so it can be looked up in fset.crcIndex.
+func (rw *rollWindow) crc(raw uint32) uint32 {
+	return ^(rw.constant ^ raw)
+}
+
+// roll advances the rolling state by dropping out the byte at the head of
+// the window and appending in at the tail.
+func (rw *rollWindow) roll(raw uint32, out, in byte) uint32 {
+	state := raw ^ rw.leaving[out]
+	return crc32.IEEETable[byte(state)^in] ^ (state >> 8)
+}
+
+// rollingScan slides a slicelen-wide window through file one byte at a time,
+// looking for blocks shifted off their slicelen-aligned offset by an earlier
+// insertion or deletion. Hits are confirmed with MD5 and folded into match;
+// the window jumps forward by slicelen on a hit since blocks don't overlap.
+func (fset *Fileset) rollingScan(file *os.File, size int64, match *FileMatch) {
+	w := fset.slicelen
+	if w == 0 || size < int64(w) {
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	br := bufio.NewReaderSize(file, 1<<20)
+
+	ring := make([]byte, w)
+	if _, err := io.ReadFull(br, ring); err != nil {
+		return
+	}
+	rw := newRollWindow(w)
+	raw := foldRaw(ring)
+	head := 0
+	offset := int64(0)
+
+	ordered := make([]byte, w)
+	windowBytes := func() []byte {
+		n := copy(ordered, ring[head:])
+		copy(ordered[n:], ring[:head])
+		return ordered
+	}
+
+	for offset+int64(w) <= size {
+		crc := rw.crc(raw)
+		hit := false
+		if candidates, ok := fset.crcIndex[crc]; ok {
+			window := windowBytes()
+			sum := md5.Sum(window)
+			for _, c := range candidates {
+				if match.File != nil && match.File != c.File {
+					continue
+				}
+				if c.File.checksums[c.blockno] != sum {
+					continue
+				}
+				if match.File == nil {
+					match.File = c.File
+				}
+				match.blocks.SetBit(match.blocks, c.blockno, 1)
+
+				// br's cursor already sits at offset+w; every byte of this
+				// window was already consumed from br to build it.
+				offset += int64(w)
+				if offset+int64(w) > size {
+					return
+				}
+				if _, err := io.ReadFull(br, ring); err != nil {
+					return
+				}
+				raw = foldRaw(ring)
+				head = 0
+				hit = true
+				break
+			}
+		}
+		if hit {
+			continue
+		}
+
+		in, err := br.ReadByte()
+		if err != nil {
+			return
+		}
+		out := ring[head]
+		ring[head] = in
+		head = (head + 1) % int(w)
+		raw = rw.roll(raw, out, in)
+		offset++
+	}
+}
@@ -0,0 +1,28 @@
+package par2
+
+import (
+	"hash/crc32"
+	"testing"
+)
+
+// TestRollWindowMatchesChecksumIEEE checks rw.crc against every window of a
+// sample string, verifying the GF(2)-linear-map rolling math reproduces
+// crc32.ChecksumIEEE exactly rather than just agreeing on the first window.
+func TestRollWindowMatchesChecksumIEEE(t *testing.T) {
+	data := []byte("The quick brown fox jumps over the lazy dog, then rolls!")
+	const w = 8
+
+	rw := newRollWindow(w)
+	ring := append([]byte(nil), data[:w]...)
+	raw := foldRaw(ring)
+
+	for off := 0; off+w <= len(data); off++ {
+		want := crc32.ChecksumIEEE(data[off : off+w])
+		if got := rw.crc(raw); got != want {
+			t.Fatalf("offset %d: got %08x, want %08x", off, got, want)
+		}
+		if off+w < len(data) {
+			raw = rw.roll(raw, data[off], data[off+w])
+		}
+	}
+}